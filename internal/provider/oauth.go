@@ -0,0 +1,31 @@
+package provider
+
+import (
+	"context"
+	"golang.org/x/oauth2"
+)
+
+// OAuthProvider holds the OAuth2 plumbing shared by every provider
+// implementation (OIDC and others). It is embedded rather than composed
+// so that provider structs can call OAuthGetLoginURL/OAuthExchangeCode
+// directly.
+type OAuthProvider struct {
+	ctx context.Context
+
+	Config *oauth2.Config
+}
+
+// OAuthGetLoginURL builds the authorization request URL for the given
+// redirect URI and state, passing through any extra authorization
+// parameters (e.g. PKCE challenge, nonce).
+func (o *OAuthProvider) OAuthGetLoginURL(redirectURI, state string, opts ...oauth2.AuthCodeOption) string {
+	o.Config.RedirectURL = redirectURI
+	return o.Config.AuthCodeURL(state, opts...)
+}
+
+// OAuthExchangeCode exchanges an authorization code for a token, passing
+// through any extra token request parameters (e.g. PKCE verifier).
+func (o *OAuthProvider) OAuthExchangeCode(redirectURI, code string, opts ...oauth2.AuthCodeOption) (*oauth2.Token, error) {
+	o.Config.RedirectURL = redirectURI
+	return o.Config.Exchange(o.ctx, code, opts...)
+}
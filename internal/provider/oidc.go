@@ -2,29 +2,62 @@ package provider
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
 	"errors"
 	"github.com/coreos/go-oidc"
 	"golang.org/x/oauth2"
+	"net/url"
 	"os"
 	"io"
 	"log"
+	"sort"
 	str "strings"
 )
 
 // OIDC provider
 type OIDC struct {
+	// ProviderName identifies this instance among several configured OIDC
+	// providers (see OIDCRouter); it has no bearing on Name(), which
+	// identifies the provider type.
+	ProviderName string `long:"provider-name" env:"PROVIDER_NAME" description:"Name used to select this provider instance among several configured OIDC providers"`
+
 	IssuerURL    string `long:"issuer-url" env:"ISSUER_URL" description:"Issuer URL"`
 	ClientID     string `long:"client-id" env:"CLIENT_ID" description:"Client ID"`
 	ClientSecret string `long:"client-secret" env:"CLIENT_SECRET" description:"Client Secret" json:"-"`
 
+	RequestOfflineAccess bool `long:"request-offline-access" env:"REQUEST_OFFLINE_ACCESS" default:"true" description:"Request the offline_access scope so the IdP issues a refresh token (required by e.g. Azure AD and Google; some IdPs reject it)"`
+
+	UserClaim   string `long:"user-claim" env:"USER_CLAIM" default:"email" description:"Claim to use as the user identifier"`
+	GroupsClaim string `long:"groups-claim" env:"GROUPS_CLAIM" default:"groups" description:"Claim to use for group membership"`
+	AdminGroup  string `long:"admin-group" env:"ADMIN_GROUP" description:"Group which, when present in the user's groups, grants the synthetic 'admin' group"`
+
+	GroupSeparator string `long:"group-separator" env:"GROUP_SEPARATOR" description:"Separator used to split nested/hierarchical group paths (e.g. Keycloak's '/parents/child'); disabled by default"`
+	GroupPrefix    string `long:"group-prefix" env:"GROUP_PREFIX" description:"Only keep groups whose full path starts with this prefix"`
+	EmitFullPath   bool   `long:"group-emit-full-path" env:"GROUP_EMIT_FULL_PATH" description:"Emit the full nested group path and each of its ancestor paths instead of its individual segments"`
+
+	EndSessionDisabled     bool   `long:"end-session-disabled" env:"END_SESSION_DISABLED" description:"Disable RP-initiated logout even if the provider advertises an end_session_endpoint"`
+	PostLogoutRedirectURI  string `long:"post-logout-redirect-uri" env:"POST_LOGOUT_REDIRECT_URI" description:"URI the IdP should redirect to once RP-initiated logout completes"`
+
 	OAuthProvider
 
 	provider *oidc.Provider
 	verifier *oidc.IDTokenVerifier
 
+	// endSessionEndpoint is discovered from the provider's discovery
+	// document during Setup; go-oidc doesn't expose it directly.
+	endSessionEndpoint string
+
 	MyLog *log.Logger
 }
 
+// ErrReauthRequired is returned by GetUser when the IdP has rejected the
+// refresh token (e.g. because the session or group membership backing it
+// was revoked). Callers must treat this the same as a failed login and
+// clear the local session rather than retrying the refresh.
+var ErrReauthRequired = errors.New("oidc: reauthentication required, refresh token rejected by provider")
+
 // Name returns the name of the provider
 func (o *OIDC) Name() string {
 	return "oidc"
@@ -51,14 +84,22 @@ func (o *OIDC) Setup() error {
 	myLog.Println("----------> OIDC.Setup")
 	o.MyLog = myLog
 
+	// "openid" is a required scope for OpenID Connect flows.
+	scopes := []string{oidc.ScopeOpenID, "profile", "email"}
+	if o.RequestOfflineAccess {
+		// Required by Azure AD and Google (which additionally wants
+		// access_type=offline, added in GetLoginURL) to ever issue a
+		// refresh_token; without it the refresh subsystem in GetUser has
+		// nothing to refresh with once the access token expires.
+		scopes = append(scopes, oidc.ScopeOfflineAccess)
+	}
+
 	// Create oauth2 config
 	o.Config = &oauth2.Config{
 		ClientID:     o.ClientID,
 		ClientSecret: o.ClientSecret,
 		Endpoint:     o.provider.Endpoint(),
-	
-		// "openid" is a required scope for OpenID Connect flows.
-		Scopes: []string{oidc.ScopeOpenID, "profile", "email"},
+		Scopes:       scopes,
 	}
 
 	// Create OIDC verifier
@@ -66,30 +107,130 @@ func (o *OIDC) Setup() error {
 		ClientID: o.ClientID,
 	})
 
+	// go-oidc doesn't surface end_session_endpoint itself, so pull it out of
+	// the raw discovery document for RP-initiated logout.
+	var discovery struct {
+		EndSessionEndpoint string `json:"end_session_endpoint"`
+	}
+	if err := o.provider.Claims(&discovery); err != nil {
+		o.MyLog.Println("----------> OIDC.Setup, failed decoding discovery document:", err)
+	} else {
+		o.endSessionEndpoint = discovery.EndSessionEndpoint
+	}
+
 	return nil
 }
 
-// GetLoginURL provides the login url for the given redirect uri and state
-func (o *OIDC) GetLoginURL(redirectURI, state string) string {
-	return o.OAuthGetLoginURL(redirectURI, state)
+// LoginParams carries the PKCE code verifier and OIDC nonce generated for a
+// login attempt. The caller must persist both in the encrypted state
+// cookie and pass them back into ExchangeCode/GetUser on the callback,
+// since they only provide replay protection if they survive the redirect.
+type LoginParams struct {
+	URL      string
+	Verifier string
+	Nonce    string
 }
 
-// ExchangeCode exchanges the given redirect uri and code for a token
-func (o *OIDC) ExchangeCode(redirectURI, code string) (string, error) {
-	token, err := o.OAuthExchangeCode(redirectURI, code)
+// GetLoginURL provides the login url for the given redirect uri and state,
+// implementing PKCE (RFC 7636) and an OIDC nonce so the authorization code
+// and ID token can't be replayed.
+func (o *OIDC) GetLoginURL(redirectURI, state string) (*LoginParams, error) {
+	verifier, err := generateCodeVerifier()
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	nonce, err := generateNonce()
+	if err != nil {
+		return nil, err
 	}
 
-	// Extract ID token
-	rawIDToken, ok := token.Extra("id_token").(string)
-	if !ok {
-		return "", errors.New("Missing id_token")
+	opts := []oauth2.AuthCodeOption{
+		oauth2.SetAuthURLParam("code_challenge", codeChallengeS256(verifier)),
+		oauth2.SetAuthURLParam("code_challenge_method", "S256"),
+		oidc.Nonce(nonce),
+	}
+	if o.RequestOfflineAccess {
+		// Google only returns a refresh_token when access_type=offline is
+		// present, in addition to the offline_access scope.
+		opts = append(opts, oauth2.AccessTypeOffline)
+	}
+
+	url := o.OAuthGetLoginURL(redirectURI, state, opts...)
+	o.MyLog.Println("----------> OIDC.GetLoginURL, url:", url)
+
+	return &LoginParams{URL: url, Verifier: verifier, Nonce: nonce}, nil
+}
+
+// ExchangeCode exchanges the given redirect uri and code for a token,
+// presenting the PKCE code verifier generated by GetLoginURL. The full
+// token is returned (rather than just the raw ID token) so that GetUser
+// can call the UserInfo endpoint and refresh the access token.
+func (o *OIDC) ExchangeCode(redirectURI, code, codeVerifier string) (*oauth2.Token, error) {
+	token, err := o.OAuthExchangeCode(redirectURI, code, oauth2.SetAuthURLParam("code_verifier", codeVerifier))
+	if err != nil {
+		return nil, err
+	}
+
+	// Make sure an ID token is actually present
+	if _, ok := token.Extra("id_token").(string); !ok {
+		return nil, errors.New("Missing id_token")
 	}
 
-	o.MyLog.Println("----------> OIDC.ExchangeCode, rawIDToken:", rawIDToken)
+	return token, nil
+}
+
+// Logout returns the URL the user should be redirected to in order to end
+// their session at the IdP (RP-initiated logout), or "" if the provider
+// doesn't advertise an end_session_endpoint or EndSessionDisabled is set
+// (some IdPs, e.g. GitHub-via-Dex, don't support this, and the caller
+// should just clear the local session cookie). idTokenHint is the raw ID
+// token issued to the user, which the IdP uses to identify the session.
+func (o *OIDC) Logout(idTokenHint string) string {
+	if o.EndSessionDisabled || o.endSessionEndpoint == "" {
+		return ""
+	}
+
+	endSessionURL, err := url.Parse(o.endSessionEndpoint)
+	if err != nil {
+		o.MyLog.Println("----------> OIDC.Logout, invalid end_session_endpoint:", err)
+		return ""
+	}
 
-	return rawIDToken, nil
+	query := endSessionURL.Query()
+	query.Set("id_token_hint", idTokenHint)
+	query.Set("client_id", o.ClientID)
+	if o.PostLogoutRedirectURI != "" {
+		query.Set("post_logout_redirect_uri", o.PostLogoutRedirectURI)
+	}
+	endSessionURL.RawQuery = query.Encode()
+
+	return endSessionURL.String()
+}
+
+// generateCodeVerifier returns a cryptographically random PKCE code
+// verifier per RFC 7636 (43-128 base64url characters).
+func generateCodeVerifier() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// codeChallengeS256 derives the PKCE S256 code challenge for verifier:
+// base64url(SHA256(verifier)).
+func codeChallengeS256(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// generateNonce returns a cryptographically random OIDC nonce.
+func generateNonce() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
 }
 
 // Keys returns the keys of the map m.
@@ -102,33 +243,175 @@ func mapKeys[M ~map[K]V, K comparable, V any](m M) []K {
 	return r
 }
 
-// GetUser uses the given token and returns a complete provider.User object
-func (o *OIDC) GetUser(token, _ string) (*User, error) {
+// claimString extracts a string value for the given key from decoded claims.
+func claimString(claims map[string]interface{}, key string) string {
+	if v, ok := claims[key].(string); ok {
+		return v
+	}
+	return ""
+}
+
+// claimStrings extracts a slice of strings for the given key from decoded
+// claims. It accepts either a JSON array (the common case) or a
+// comma-separated string, since some IdPs emit multi-valued claims that way.
+func claimStrings(claims map[string]interface{}, key string) []string {
+	switch v := claims[key].(type) {
+	case []interface{}:
+		r := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				r = append(r, s)
+			}
+		}
+		return r
+	case string:
+		return str.Split(v, ",")
+	default:
+		return nil
+	}
+}
+
+// flattenGroups turns the raw group claim values into a deterministic,
+// de-duplicated, sorted list of group names. Splitting is disabled by
+// default: unconditionally splitting on "/" mangles group names that
+// legitimately contain it (LDAP DNs, Azure AD object IDs). When
+// GroupSeparator is set, hierarchical paths (e.g. Keycloak's
+// "/parents/child") are split on it instead. GroupPrefix restricts the
+// output to groups under a given path, and EmitFullPath additionally keeps
+// every ancestor of a path alongside the full path.
+func (o *OIDC) flattenGroups(groups []string) []string {
+	groupSet := make(map[string]bool)
+	for _, groupFull := range groups {
+		if o.GroupPrefix != "" && !str.HasPrefix(groupFull, o.GroupPrefix) {
+			continue
+		}
+
+		if o.GroupSeparator == "" {
+			groupSet[groupFull] = true
+			continue
+		}
+
+		var segments []string
+		for _, segment := range str.Split(groupFull, o.GroupSeparator) {
+			if segment != "" {
+				segments = append(segments, segment)
+			}
+		}
+		if len(segments) == 0 {
+			continue
+		}
+
+		if !o.EmitFullPath {
+			for _, segment := range segments {
+				groupSet[segment] = true
+			}
+			continue
+		}
+
+		for i := 1; i <= len(segments); i++ {
+			groupSet[str.Join(segments[:i], o.GroupSeparator)] = true
+		}
+	}
+
+	result := mapKeys(groupSet)
+	sort.Strings(result)
+	return result
+}
+
+// GetUser uses the given token and returns a complete provider.User object.
+// nonce is the value generated by GetLoginURL and must match the ID
+// token's nonce claim, guarding against token replay. Claims are
+// preferably read from the provider's UserInfo endpoint, falling back to
+// the ID token's claims when UserInfo is unsupported or doesn't carry
+// group membership, since many IdPs (Keycloak, Azure, Google) only emit
+// groups via UserInfo.
+func (o *OIDC) GetUser(token *oauth2.Token, nonce string) (*User, error) {
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, errors.New("Missing id_token")
+	}
+
 	// Parse & Verify ID Token
-	idToken, err := o.verifier.Verify(o.ctx, token)
+	idToken, err := o.verifier.Verify(o.ctx, rawIDToken)
 	if err != nil {
 		return nil, err
 	}
+	if idToken.Nonce != nonce {
+		return nil, errors.New("nonce mismatch")
+	}
 
-	// Extract custom claims
-	var user struct {
-		Email string `json:"email"`
-		Groups []string `json:"groups"`
+	// Extract claims as a generic map so UserClaim/GroupsClaim can name
+	// arbitrary fields; IdPs disagree wildly on what they call things.
+	claims := make(map[string]interface{})
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, err
 	}
-	if err := idToken.Claims(&user); err != nil {
+
+	// Refresh the access token if it is stale. token is mutated in place so
+	// that the caller can persist the new token, and its expiry, back into
+	// the session cookie. If the IdP has revoked the refresh token it
+	// replies with invalid_grant, which we surface as ErrReauthRequired so
+	// the caller logs the user out instead of looping on a dead session.
+	if refreshed, err := o.Config.TokenSource(o.ctx, token).Token(); err != nil {
+		var retrieveErr *oauth2.RetrieveError
+		if errors.As(err, &retrieveErr) && str.Contains(string(retrieveErr.Body), "invalid_grant") {
+			o.MyLog.Println("----------> OIDC.GetUser, refresh rejected with invalid_grant, forcing re-auth")
+			return nil, ErrReauthRequired
+		}
 		return nil, err
+	} else if refreshed.AccessToken != token.AccessToken {
+		o.MyLog.Println("----------> OIDC.GetUser, access token refreshed, new expiry:", refreshed.Expiry)
+		*token = *refreshed
 	}
-	o.MyLog.Println("----------> OIDC.GetUser, user:", user)
 
-	groupMap := make(map[string]bool)
-	for _, groupFull := range user.Groups {
-		for _, group := range str.Split(groupFull, "/") {
-			if group != "" {
-				o.MyLog.Println("----------> OIDC.GetUser, group:", group)
-				groupMap[group] = true
-			}
+	if userInfo, err := o.provider.UserInfo(o.ctx, oauth2.StaticTokenSource(token)); err != nil {
+		o.MyLog.Println("----------> OIDC.GetUser, UserInfo unsupported/failed, using ID token claims:", err)
+	} else {
+		userInfoClaims := make(map[string]interface{})
+		if err := userInfo.Claims(&userInfoClaims); err != nil {
+			o.MyLog.Println("----------> OIDC.GetUser, failed decoding UserInfo claims, using ID token claims:", err)
+		} else if len(claimStrings(userInfoClaims, o.GroupsClaim)) == 0 {
+			o.MyLog.Println("----------> OIDC.GetUser, UserInfo has no groups claim, using ID token claims")
+		} else {
+			claims = userInfoClaims
 		}
 	}
-	uniqueGrops := mapKeys(groupMap)
-	return &User{User: user.Email, Groups: uniqueGrops, }, nil
+	o.MyLog.Println("----------> OIDC.GetUser, claims:", claims)
+
+	groups := o.flattenGroups(claimStrings(claims, o.GroupsClaim))
+	o.MyLog.Println("----------> OIDC.GetUser, groups:", groups)
+
+	if merged, matched := mergeAdminGroup(groups, o.AdminGroup); matched {
+		o.MyLog.Println("----------> OIDC.GetUser, admin group matched:", o.AdminGroup)
+		groups = merged
+	}
+	return &User{User: claimString(claims, o.UserClaim), Groups: groups}, nil
+}
+
+// mergeAdminGroup adds the synthetic "admin" group to a sorted,
+// de-duplicated groups list when adminGroup is set and present in groups.
+// It reports via matched whether the addition happened, and is a no-op
+// (matched == false) if adminGroup is empty, isn't present, or "admin" is
+// already in groups - e.g. it happens to equal a real group name.
+func mergeAdminGroup(groups []string, adminGroup string) (result []string, matched bool) {
+	if adminGroup == "" {
+		return groups, false
+	}
+
+	isAdmin, hasAdmin := false, false
+	for _, group := range groups {
+		if group == adminGroup {
+			isAdmin = true
+		}
+		if group == "admin" {
+			hasAdmin = true
+		}
+	}
+	if !isAdmin || hasAdmin {
+		return groups, false
+	}
+
+	merged := append(append([]string{}, groups...), "admin")
+	sort.Strings(merged)
+	return merged, true
 }
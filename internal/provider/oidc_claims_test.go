@@ -0,0 +1,51 @@
+package provider
+
+import "testing"
+
+func TestClaimString(t *testing.T) {
+	claims := map[string]interface{}{
+		"email": "user@example.com",
+		"sub":   123,
+	}
+
+	if got := claimString(claims, "email"); got != "user@example.com" {
+		t.Errorf("claimString(email) = %q, want %q", got, "user@example.com")
+	}
+	if got := claimString(claims, "sub"); got != "" {
+		t.Errorf("claimString(sub) = %q, want empty for non-string value", got)
+	}
+	if got := claimString(claims, "missing"); got != "" {
+		t.Errorf("claimString(missing) = %q, want empty", got)
+	}
+}
+
+func TestClaimStrings(t *testing.T) {
+	claims := map[string]interface{}{
+		"array":  []interface{}{"a", "b", 1},
+		"csv":    "a,b,c",
+		"single": "solo",
+		"number": 42,
+	}
+
+	assertStrings(t, claimStrings(claims, "array"), []string{"a", "b"})
+	assertStrings(t, claimStrings(claims, "csv"), []string{"a", "b", "c"})
+	assertStrings(t, claimStrings(claims, "single"), []string{"solo"})
+	if got := claimStrings(claims, "number"); got != nil {
+		t.Errorf("claimStrings(number) = %v, want nil", got)
+	}
+	if got := claimStrings(claims, "missing"); got != nil {
+		t.Errorf("claimStrings(missing) = %v, want nil", got)
+	}
+}
+
+func assertStrings(t *testing.T, got, want []string) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package provider
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFlattenGroupsNoSeparator(t *testing.T) {
+	o := &OIDC{}
+	got := o.flattenGroups([]string{"b/ops", "a", "a"})
+	want := []string{"a", "b/ops"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("flattenGroups() = %v, want %v (no splitting without GroupSeparator)", got, want)
+	}
+}
+
+func TestFlattenGroupsSeparatorSplitsSegments(t *testing.T) {
+	o := &OIDC{GroupSeparator: "/"}
+	got := o.flattenGroups([]string{"/parents/child", "//double//slash/"})
+	want := []string{"child", "double", "parents", "slash"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("flattenGroups() = %v, want %v", got, want)
+	}
+}
+
+func TestFlattenGroupsEmitFullPath(t *testing.T) {
+	o := &OIDC{GroupSeparator: "/", EmitFullPath: true}
+	got := o.flattenGroups([]string{"/parents/child"})
+	want := []string{"parents", "parents/child"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("flattenGroups() = %v, want %v (should keep every ancestor plus the full path)", got, want)
+	}
+}
+
+func TestFlattenGroupsPrefixFilter(t *testing.T) {
+	o := &OIDC{GroupPrefix: "/traefik/"}
+	got := o.flattenGroups([]string{"/traefik/admins", "/other/team"})
+	want := []string{"/traefik/admins"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("flattenGroups() = %v, want %v", got, want)
+	}
+}
+
+func TestMergeAdminGroup(t *testing.T) {
+	cases := []struct {
+		name       string
+		groups     []string
+		adminGroup string
+		wantGroups []string
+		wantMatch  bool
+	}{
+		{"no admin group configured", []string{"ops"}, "", []string{"ops"}, false},
+		{"admin group absent", []string{"ops"}, "sre", []string{"ops"}, false},
+		{"admin group present", []string{"ops"}, "ops", []string{"admin", "ops"}, true},
+		{
+			// Regression test: if AdminGroup is itself "admin" (or the IdP
+			// happens to name a real group "admin"), it must not be added twice.
+			"admin group equals synthetic group name",
+			[]string{"admin", "ops"}, "admin",
+			[]string{"admin", "ops"}, false,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, matched := mergeAdminGroup(c.groups, c.adminGroup)
+			if matched != c.wantMatch {
+				t.Errorf("matched = %v, want %v", matched, c.wantMatch)
+			}
+			if !reflect.DeepEqual(got, c.wantGroups) {
+				t.Errorf("groups = %v, want %v", got, c.wantGroups)
+			}
+
+			count := 0
+			for _, g := range got {
+				if g == "admin" {
+					count++
+				}
+			}
+			if count > 1 {
+				t.Errorf("groups = %v contains %d copies of %q, want at most 1", got, count, "admin")
+			}
+		})
+	}
+}
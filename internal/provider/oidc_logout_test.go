@@ -0,0 +1,62 @@
+package provider
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestLogoutDisabled(t *testing.T) {
+	o := &OIDC{EndSessionDisabled: true, endSessionEndpoint: "https://idp.example.com/logout"}
+	if got := o.Logout("id-token"); got != "" {
+		t.Errorf("Logout() = %q, want empty when EndSessionDisabled is set", got)
+	}
+}
+
+func TestLogoutNoEndSessionEndpoint(t *testing.T) {
+	o := &OIDC{}
+	if got := o.Logout("id-token"); got != "" {
+		t.Errorf("Logout() = %q, want empty when the provider has no end_session_endpoint", got)
+	}
+}
+
+func TestLogoutBuildsRedirectURL(t *testing.T) {
+	o := &OIDC{
+		ClientID:              "my-client",
+		PostLogoutRedirectURI: "https://app.example.com/",
+		endSessionEndpoint:    "https://idp.example.com/logout",
+	}
+
+	got := o.Logout("the-id-token")
+	parsed, err := url.Parse(got)
+	if err != nil {
+		t.Fatalf("Logout() returned an unparseable URL %q: %v", got, err)
+	}
+
+	query := parsed.Query()
+	if q := query.Get("id_token_hint"); q != "the-id-token" {
+		t.Errorf("id_token_hint = %q, want %q", q, "the-id-token")
+	}
+	if q := query.Get("client_id"); q != "my-client" {
+		t.Errorf("client_id = %q, want %q", q, "my-client")
+	}
+	if q := query.Get("post_logout_redirect_uri"); q != "https://app.example.com/" {
+		t.Errorf("post_logout_redirect_uri = %q, want %q", q, "https://app.example.com/")
+	}
+}
+
+func TestLogoutOmitsPostLogoutRedirectURIWhenUnconfigured(t *testing.T) {
+	o := &OIDC{
+		ClientID:           "my-client",
+		endSessionEndpoint: "https://idp.example.com/logout",
+	}
+
+	got := o.Logout("the-id-token")
+	parsed, err := url.Parse(got)
+	if err != nil {
+		t.Fatalf("Logout() returned an unparseable URL %q: %v", got, err)
+	}
+
+	if _, ok := parsed.Query()["post_logout_redirect_uri"]; ok {
+		t.Errorf("post_logout_redirect_uri present in %q, want omitted when PostLogoutRedirectURI is unset", got)
+	}
+}
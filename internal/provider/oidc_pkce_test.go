@@ -0,0 +1,55 @@
+package provider
+
+import "testing"
+
+func TestGenerateCodeVerifierLengthAndUniqueness(t *testing.T) {
+	verifier, err := generateCodeVerifier()
+	if err != nil {
+		t.Fatalf("generateCodeVerifier() error = %v", err)
+	}
+	// RFC 7636 requires 43-128 characters.
+	if len(verifier) < 43 || len(verifier) > 128 {
+		t.Errorf("len(verifier) = %d, want between 43 and 128", len(verifier))
+	}
+
+	other, err := generateCodeVerifier()
+	if err != nil {
+		t.Fatalf("generateCodeVerifier() error = %v", err)
+	}
+	if verifier == other {
+		t.Error("generateCodeVerifier() returned the same value twice, want cryptographically random")
+	}
+}
+
+func TestCodeChallengeS256IsDeterministicAndDiffersFromVerifier(t *testing.T) {
+	verifier := "a-fixed-test-verifier-value-0123456789"
+
+	challenge := codeChallengeS256(verifier)
+	if challenge != codeChallengeS256(verifier) {
+		t.Error("codeChallengeS256() not deterministic for the same verifier")
+	}
+	if challenge == verifier {
+		t.Error("codeChallengeS256() returned the verifier unchanged")
+	}
+	if codeChallengeS256(verifier+"x") == challenge {
+		t.Error("codeChallengeS256() produced the same challenge for different verifiers")
+	}
+}
+
+func TestGenerateNonceUniqueness(t *testing.T) {
+	nonce, err := generateNonce()
+	if err != nil {
+		t.Fatalf("generateNonce() error = %v", err)
+	}
+	if nonce == "" {
+		t.Fatal("generateNonce() returned empty string")
+	}
+
+	other, err := generateNonce()
+	if err != nil {
+		t.Fatalf("generateNonce() error = %v", err)
+	}
+	if nonce == other {
+		t.Error("generateNonce() returned the same value twice, want cryptographically random")
+	}
+}
@@ -0,0 +1,93 @@
+package provider
+
+import (
+	"fmt"
+	"net/http"
+	str "strings"
+)
+
+// ProviderQueryParam is the login endpoint query parameter used to pick a
+// provider explicitly, e.g. GET /_oauth/login?provider=keycloak.
+const ProviderQueryParam = "provider"
+
+// ProviderHeader carries the per-rule Traefik label value used to pick a
+// provider. It is a trust-boundary header, not a public API: it must be
+// injected by Traefik itself (via a forwardAuth requestHeaders label) and
+// stripped from whatever the client originally sent, the same way
+// X-Forwarded-* headers must be scrubbed at the edge. If this service is
+// ever reachable directly, or Traefik is misconfigured to pass the client's
+// own copy through, a caller can force selection of any configured
+// provider. Prefer HostProviders (fully server-side config) wherever the
+// deployment topology allows it.
+const ProviderHeader = "X-Forward-Auth-Provider"
+
+// stateProviderSeparator joins the provider name and the caller's state so
+// the callback can recover which provider to dispatch to; go-oidc's
+// state/nonce handling only round-trips a single opaque string.
+const stateProviderSeparator = "|"
+
+// OIDCRouter dispatches login/callback traffic to one of several named OIDC
+// providers, selected by incoming host, a "?provider=" query parameter, or
+// a per-rule Traefik label. This lets one deployment federate multiple
+// IdPs (e.g. an internal Keycloak plus GitHub-via-Dex), mirroring the
+// connector pattern used by Dex.
+type OIDCRouter struct {
+	// Providers maps a provider name to its configured OIDC instance.
+	Providers map[string]*OIDC
+
+	// HostProviders maps an incoming request host to a provider name.
+	HostProviders map[string]string
+
+	// DefaultProvider is used when no host, query parameter or label match.
+	DefaultProvider string `long:"default-provider" env:"DEFAULT_PROVIDER" description:"Provider name used when none is selected by host, query parameter or label"`
+}
+
+// Select picks the provider name to use for the given request: an explicit
+// "?provider=" query parameter wins (it is deliberately public, e.g. a
+// login page lets the user choose their IdP), then a match on the incoming
+// host (fully server-side config, so it is trustworthy regardless of
+// deployment), then the per-rule Traefik label (see the ProviderHeader
+// trust-boundary note - only safe if the edge strips client-supplied
+// copies), finally DefaultProvider.
+func (r *OIDCRouter) Select(req *http.Request) string {
+	if name := req.URL.Query().Get(ProviderQueryParam); name != "" {
+		return name
+	}
+	if name, ok := r.HostProviders[req.Host]; ok {
+		return name
+	}
+	if name := req.Header.Get(ProviderHeader); name != "" {
+		return name
+	}
+	return r.DefaultProvider
+}
+
+// Get returns the named provider, or an error if it isn't configured.
+func (r *OIDCRouter) Get(name string) (*OIDC, error) {
+	o, ok := r.Providers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown oidc provider %q", name)
+	}
+	return o, nil
+}
+
+// GetLoginURL resolves the provider for req, then returns its login
+// params with the provider name embedded in the state so the callback can
+// dispatch back to the same provider.
+func (r *OIDCRouter) GetLoginURL(req *http.Request, redirectURI, state string) (*LoginParams, error) {
+	name := r.Select(req)
+	o, err := r.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	return o.GetLoginURL(redirectURI, name+stateProviderSeparator+state)
+}
+
+// SplitProviderState recovers the provider name and the original state
+// embedded by GetLoginURL.
+func SplitProviderState(state string) (providerName, rest string) {
+	if idx := str.Index(state, stateProviderSeparator); idx >= 0 {
+		return state[:idx], state[idx+len(stateProviderSeparator):]
+	}
+	return "", state
+}
@@ -0,0 +1,74 @@
+package provider
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestOIDCRouterSelect(t *testing.T) {
+	r := &OIDCRouter{
+		HostProviders:   map[string]string{"internal.example.com": "keycloak"},
+		DefaultProvider: "dex",
+	}
+
+	cases := []struct {
+		name   string
+		target string
+		header string
+		host   string
+		want   string
+	}{
+		{"query param wins", "/_oauth/login?provider=github", "", "internal.example.com", "github"},
+		{"host config wins over header", "/_oauth/login", "header-provider", "internal.example.com", "keycloak"},
+		{"header used when no query or host match", "/_oauth/login", "header-provider", "unknown.example.com", "header-provider"},
+		{"falls back to default", "/_oauth/login", "", "unknown.example.com", "dex"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, c.target, nil)
+			req.Host = c.host
+			if c.header != "" {
+				req.Header.Set(ProviderHeader, c.header)
+			}
+
+			if got := r.Select(req); got != c.want {
+				t.Errorf("Select() = %q, want %q", got, c.want)
+			}
+		})
+	}
+}
+
+func TestOIDCRouterGet(t *testing.T) {
+	keycloak := &OIDC{}
+	r := &OIDCRouter{Providers: map[string]*OIDC{"keycloak": keycloak}}
+
+	got, err := r.Get("keycloak")
+	if err != nil || got != keycloak {
+		t.Errorf("Get(keycloak) = (%v, %v), want (%v, nil)", got, err, keycloak)
+	}
+
+	if _, err := r.Get("missing"); err == nil {
+		t.Error("Get(missing) error = nil, want non-nil for an unconfigured provider")
+	}
+}
+
+func TestSplitProviderState(t *testing.T) {
+	cases := []struct {
+		state        string
+		wantProvider string
+		wantRest     string
+	}{
+		{"keycloak|abc123", "keycloak", "abc123"},
+		{"keycloak|", "keycloak", ""},
+		{"no-separator-state", "", "no-separator-state"},
+	}
+
+	for _, c := range cases {
+		provider, rest := SplitProviderState(c.state)
+		if provider != c.wantProvider || rest != c.wantRest {
+			t.Errorf("SplitProviderState(%q) = (%q, %q), want (%q, %q)", c.state, provider, rest, c.wantProvider, c.wantRest)
+		}
+	}
+}